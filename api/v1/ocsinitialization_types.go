@@ -0,0 +1,22 @@
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// OCSInitialization is the Schema for the ocsinitializations API. It carries
+// cluster-wide defaults that apply unless a StorageCluster overrides them.
+type OCSInitialization struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec OCSInitializationSpec `json:"spec,omitempty"`
+}
+
+// OCSInitializationSpec defines the desired state of OCSInitialization.
+type OCSInitializationSpec struct {
+	// EnableCephTools is the cluster-wide default for deploying the
+	// rook-ceph-tools toolbox; a StorageCluster's own EnableCephTools wins
+	// when set.
+	EnableCephTools bool `json:"enableCephTools,omitempty"`
+}
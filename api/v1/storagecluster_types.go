@@ -0,0 +1,116 @@
+package v1
+
+import (
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// StorageCluster is the Schema for the storageclusters API.
+//
+// NOTE: this is a minimal reconstruction of the fields the storagecluster
+// controller actually reads/writes; it is not the full upstream CRD.
+type StorageCluster struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   StorageClusterSpec   `json:"spec,omitempty"`
+	Status StorageClusterStatus `json:"status,omitempty"`
+}
+
+// StorageClusterSpec defines the desired state of StorageCluster.
+type StorageClusterSpec struct {
+	// EnableCephTools toggles deployment of the rook-ceph-tools toolbox.
+	EnableCephTools bool `json:"enableCephTools,omitempty"`
+
+	ManagedResources ManagedResourcesSpec `json:"managedResources,omitempty"`
+
+	// CSI holds settings that are plumbed into the reconciled ceph-csi
+	// provisioner/node plugin config and generated StorageClasses.
+	CSI CSISpec `json:"csi,omitempty"`
+
+	// Mirroring, when set, enables the RBD mirror peer bootstrap subsystem
+	// for this StorageCluster's mirrored CephBlockPools.
+	Mirroring *MirroringSpec `json:"mirroring,omitempty"`
+}
+
+// ManagedResourcesSpec defines how the operator manages auxiliary resources.
+type ManagedResourcesSpec struct {
+	CephToolbox CephToolboxSpec `json:"cephToolbox,omitempty"`
+}
+
+// CephToolboxSpec configures the reconciled rook-ceph-tools deployment.
+type CephToolboxSpec struct {
+	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
+}
+
+// CSISpec configures ceph-csi provisioning behavior.
+type CSISpec struct {
+	// TopologyDomainLabels lists the node labels (e.g. zone/rack) that the
+	// CSI provisioner and node plugin should use as topology domains, and
+	// that generated RBD/CephFS StorageClasses should honor via
+	// WaitForFirstConsumer binding and allowedTopologies.
+	TopologyDomainLabels []string `json:"topologyDomainLabels,omitempty"`
+}
+
+// MirroringSpec configures the RBD mirror peer bootstrap subsystem for a
+// StorageCluster.
+type MirroringSpec struct {
+	// PeerSecretNames references Secrets, each holding a bootstrap token
+	// produced by a peer cluster's StorageCluster, to import as rx-tx
+	// relationships.
+	PeerSecretNames []string `json:"peerSecretNames,omitempty"`
+}
+
+// StorageClusterStatus defines the observed state of StorageCluster.
+type StorageClusterStatus struct {
+	Mirroring MirroringStatusSpec `json:"mirroring,omitempty"`
+}
+
+// MirroringStatusSpec reports the bootstrap state of every mirrored pool's
+// peer relationships.
+type MirroringStatusSpec struct {
+	Peers []MirroringPeerStatus `json:"peers,omitempty"`
+}
+
+// MirroringPeerStatus is the observed bootstrap/import state for a single
+// mirrored CephBlockPool.
+type MirroringPeerStatus struct {
+	PoolName   string `json:"poolName"`
+	SecretName string `json:"secretName"`
+	PeerUUID   string `json:"peerUUID,omitempty"`
+	State      string `json:"state,omitempty"`
+	Imported   bool   `json:"imported"`
+}
+
+// NewToolsDeployment returns the Deployment spec for the rook-ceph-tools
+// toolbox, tolerating exactly the given tolerations; it adds none of its
+// own, so callers pass in any toleration the operator always applies.
+func (sc *StorageCluster) NewToolsDeployment(tolerations []corev1.Toleration) *appsv1.Deployment {
+	replicas := int32(1)
+	labels := map[string]string{"app": "rook-ceph-tools"}
+
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "rook-ceph-tools",
+			Namespace: sc.Namespace,
+			Labels:    labels,
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					Tolerations: tolerations,
+					Containers: []corev1.Container{
+						{
+							Name:  "rook-ceph-tools",
+							Image: "rook/ceph:master",
+						},
+					},
+				},
+			},
+		},
+	}
+}
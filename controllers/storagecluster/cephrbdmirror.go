@@ -0,0 +1,273 @@
+package storagecluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+
+	ocsv1 "github.com/red-hat-storage/ocs-operator/api/v1"
+	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	// rbdMirrorDaemonName is the name of the reconciled CephRBDMirror CR
+	rbdMirrorDaemonName = "rbd-mirror"
+
+	// rbdMirrorBootstrapTokenSecretPrefix prefixes the Secret that stores the
+	// bootstrap peer token generated for a given CephBlockPool
+	rbdMirrorBootstrapTokenSecretPrefix = "rbd-mirror-bootstrap-peer"
+)
+
+// ensureRBDMirrorPeer reconciles the RBD mirror peer bootstrap token subsystem
+// for a StorageCluster. For every CephBlockPool marked for mirroring, it
+// bootstraps (or re-bootstraps) a peer token, imports any peer tokens the
+// StorageCluster references, and keeps a CephRBDMirror daemon running so the
+// generated relationships actually get serviced.
+func (r *StorageClusterReconciler) ensureRBDMirrorPeer(sc *ocsv1.StorageCluster) error {
+	if sc.Spec.Mirroring == nil {
+		return nil
+	}
+
+	if err := r.ensureCephRBDMirror(sc); err != nil {
+		return err
+	}
+
+	cephBlockPoolList := &cephv1.CephBlockPoolList{}
+	if err := r.Client.List(context.TODO(), cephBlockPoolList, client.InNamespace(sc.Namespace)); err != nil {
+		return fmt.Errorf("failed to list CephBlockPools in namespace %q: %v", sc.Namespace, err)
+	}
+
+	status := ocsv1.MirroringStatusSpec{}
+
+	for i := range cephBlockPoolList.Items {
+		pool := &cephBlockPoolList.Items[i]
+		if !pool.Spec.Mirroring.Enabled {
+			continue
+		}
+
+		peerInfo, err := r.ensureBootstrapTokenSecret(sc, pool)
+		if err != nil {
+			return fmt.Errorf("failed to ensure bootstrap token for pool %q: %v", pool.Name, err)
+		}
+		status.Peers = append(status.Peers, *peerInfo)
+	}
+
+	for _, secretName := range sc.Spec.Mirroring.PeerSecretNames {
+		if err := r.importPeerToken(sc, secretName); err != nil {
+			return fmt.Errorf("failed to import peer token from secret %q: %v", secretName, err)
+		}
+	}
+
+	sc.Status.Mirroring = status
+
+	return nil
+}
+
+// ensureCephRBDMirror reconciles the CephRBDMirror CR that runs the rbd-mirror
+// daemon actually responsible for replaying the bootstrapped relationships.
+func (r *StorageClusterReconciler) ensureCephRBDMirror(sc *ocsv1.StorageCluster) error {
+	rbdMirror := &cephv1.CephRBDMirror{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      rbdMirrorDaemonName,
+			Namespace: sc.Namespace,
+		},
+	}
+
+	mutateFn := func() error {
+		rbdMirror.Spec.Count = 1
+		return controllerutil.SetControllerReference(sc, rbdMirror, r.Scheme)
+	}
+
+	_, err := controllerutil.CreateOrUpdate(context.TODO(), r.Client, rbdMirror, mutateFn)
+	if err != nil {
+		return fmt.Errorf("failed to create/update CephRBDMirror %q: %v", rbdMirrorDaemonName, err)
+	}
+
+	return nil
+}
+
+// ensureBootstrapTokenSecret makes sure a peer bootstrap token for the given
+// pool exists, is stored under a stable Secret name, and reflects the pool's
+// current peer state. It re-issues the token whenever the Secret is missing,
+// since that is the only sign-of-truth the bootstrap create command left
+// behind.
+func (r *StorageClusterReconciler) ensureBootstrapTokenSecret(sc *ocsv1.StorageCluster, pool *cephv1.CephBlockPool) (*ocsv1.MirroringPeerStatus, error) {
+	secretName := rbdMirrorBootstrapTokenSecretName(pool.Name)
+
+	found := &corev1.Secret{}
+	err := r.Client.Get(context.TODO(), types.NamespacedName{Name: secretName, Namespace: sc.Namespace}, found)
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, err
+	}
+
+	alreadyImported, peerUUID, state, infoErr := r.rbdMirrorPoolPeerInfo(sc, pool.Name)
+	if infoErr != nil {
+		return nil, infoErr
+	}
+
+	if errors.IsNotFound(err) {
+		token, bootstrapErr := r.rbdMirrorPoolPeerBootstrapCreate(sc, pool.Name)
+		if bootstrapErr != nil {
+			return nil, bootstrapErr
+		}
+
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      secretName,
+				Namespace: sc.Namespace,
+			},
+			Data: map[string][]byte{
+				"token":    token,
+				"poolName": []byte(pool.Name),
+			},
+		}
+		if err := controllerutil.SetControllerReference(sc, secret, r.Scheme); err != nil {
+			return nil, err
+		}
+		if err := r.Client.Create(context.TODO(), secret); err != nil {
+			return nil, err
+		}
+	}
+
+	return &ocsv1.MirroringPeerStatus{
+		PoolName:   pool.Name,
+		SecretName: secretName,
+		PeerUUID:   peerUUID,
+		State:      state,
+		Imported:   alreadyImported,
+	}, nil
+}
+
+// importPeerToken reads the peer cluster's bootstrap token from the
+// referenced Secret and imports it as a two-way (rx-tx) relationship.
+func (r *StorageClusterReconciler) importPeerToken(sc *ocsv1.StorageCluster, secretName string) error {
+	secret := &corev1.Secret{}
+	err := r.Client.Get(context.TODO(), types.NamespacedName{Name: secretName, Namespace: sc.Namespace}, secret)
+	if err != nil {
+		return err
+	}
+
+	token, ok := secret.Data["token"]
+	if !ok {
+		return fmt.Errorf("secret %q does not contain a token", secretName)
+	}
+
+	poolName, ok := secret.Data["poolName"]
+	if !ok {
+		return fmt.Errorf("secret %q does not contain a poolName", secretName)
+	}
+
+	alreadyImported, _, _, err := r.rbdMirrorPoolPeerInfo(sc, string(poolName))
+	if err != nil {
+		return err
+	}
+	if alreadyImported {
+		return nil
+	}
+
+	return r.rbdMirrorPoolPeerBootstrapImport(sc, string(poolName), token)
+}
+
+func rbdMirrorBootstrapTokenSecretName(poolName string) string {
+	return fmt.Sprintf("%s-%s", rbdMirrorBootstrapTokenSecretPrefix, poolName)
+}
+
+// rbdMirrorTokenStagingDir is where bootstrap peer tokens are staged before
+// `rbd mirror pool peer bootstrap import` reads them from disk.
+const rbdMirrorTokenStagingDir = "/tmp"
+
+// rbdMirrorTokenFilePath returns where a pool's bootstrap token is staged.
+// This must be staged through r.CephCLIExecutor rather than written with
+// os.WriteFile directly, since that writes to the controller's own
+// filesystem, not wherever CephCLIExecutor actually runs `rbd` (e.g. inside
+// the rook-ceph-tools toolbox pod when using ToolboxExec).
+func rbdMirrorTokenFilePath(poolName string) string {
+	return filepath.Join(rbdMirrorTokenStagingDir, fmt.Sprintf("rbd-mirror-bootstrap-token-%s", poolName))
+}
+
+/* rbd CLI helpers */
+
+// rbdMirrorPoolPeerBootstrapCreate runs `rbd mirror pool peer bootstrap
+// create` for the pool and returns the opaque token it produces.
+func (r *StorageClusterReconciler) rbdMirrorPoolPeerBootstrapCreate(sc *ocsv1.StorageCluster, poolName string) ([]byte, error) {
+	args := []string{"mirror", "pool", "peer", "bootstrap", "create", "--site-name", sc.Name, poolName}
+	return r.CephCLIExecutor.Execute(context.TODO(), "rbd", args)
+}
+
+// rbdMirrorPoolPeerBootstrapImport runs `rbd mirror pool peer bootstrap
+// import` to establish a two-way (rx-tx) relationship with the peer whose
+// token is passed in.
+func (r *StorageClusterReconciler) rbdMirrorPoolPeerBootstrapImport(sc *ocsv1.StorageCluster, poolName string, token []byte) error {
+	tokenFile := rbdMirrorTokenFilePath(poolName)
+	if err := r.CephCLIExecutor.WriteFile(context.TODO(), tokenFile, token); err != nil {
+		return fmt.Errorf("failed to stage bootstrap token for pool %q: %v", poolName, err)
+	}
+
+	args := []string{"mirror", "pool", "peer", "bootstrap", "import", "--site-name", sc.Name, "--direction", "rx-tx", poolName, tokenFile}
+	_, err := r.CephCLIExecutor.Execute(context.TODO(), "rbd", args)
+	return err
+}
+
+// rbdMirrorPoolPeerInfo reports whether the pool already has an imported
+// peer, along with its UUID and mirroring state, by inspecting `rbd mirror
+// pool info` and `rbd mirror pool status`.
+func (r *StorageClusterReconciler) rbdMirrorPoolPeerInfo(sc *ocsv1.StorageCluster, poolName string) (imported bool, peerUUID string, state string, err error) {
+	args := []string{"mirror", "pool", "info", poolName, "--format", "json"}
+	out, err := r.CephCLIExecutor.Execute(context.TODO(), "rbd", args)
+	if err != nil {
+		return false, "", "", err
+	}
+
+	var info struct {
+		Peers []struct {
+			UUID      string `json:"uuid"`
+			Site      string `json:"site_name"`
+			Direction string `json:"direction"`
+		} `json:"peers"`
+	}
+	if err := json.Unmarshal(out, &info); err != nil {
+		return false, "", "", err
+	}
+
+	if len(info.Peers) == 0 {
+		return false, "", "", nil
+	}
+
+	state, err = r.rbdMirrorPoolPeerState(sc, poolName)
+	if err != nil {
+		return false, "", "", err
+	}
+
+	return true, info.Peers[0].UUID, state, nil
+}
+
+// rbdMirrorPoolPeerState reports the pool's mirroring health, as surfaced by
+// `rbd mirror pool status`, for use as the peer's reported state.
+// info.Peers[].Direction (rx-tx/rx-only) describes which way images
+// replicate, not whether the relationship is actually healthy, so it isn't
+// a substitute for a real state.
+func (r *StorageClusterReconciler) rbdMirrorPoolPeerState(sc *ocsv1.StorageCluster, poolName string) (string, error) {
+	args := []string{"mirror", "pool", "status", poolName, "--format", "json"}
+	out, err := r.CephCLIExecutor.Execute(context.TODO(), "rbd", args)
+	if err != nil {
+		return "", err
+	}
+
+	var status struct {
+		Summary struct {
+			Health string `json:"health"`
+		} `json:"summary"`
+	}
+	if err := json.Unmarshal(out, &status); err != nil {
+		return "", err
+	}
+
+	return status.Summary.Health, nil
+}
@@ -8,7 +8,9 @@ import (
 	"github.com/red-hat-storage/ocs-operator/controllers/defaults"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 )
@@ -17,9 +19,51 @@ const (
 
 	// RookCephToolDeploymentName is the name of the rook-ceph-tools deployment
 	rookCephToolDeploymentName = "rook-ceph-tools"
+
+	// toolboxExecRoleName is the Role that grants exec access into the
+	// rook-ceph-tools deployment to callers of cephcli.ToolboxExec.
+	toolboxExecRoleName = "rook-ceph-tools-exec"
+
+	// toolboxExecRoleBindingName binds toolboxExecRoleName to the
+	// ServiceAccounts passed in as extraSubjects.
+	toolboxExecRoleBindingName = "rook-ceph-tools-exec"
+
+	// ocsMetricsExporterServiceAccountName is the ServiceAccount the metrics
+	// exporter runs as; it needs exec access into rook-ceph-tools to use
+	// cephcli.ToolboxExec.
+	ocsMetricsExporterServiceAccountName = "ocs-metrics-exporter"
 )
 
-func (r *StorageClusterReconciler) ensureToolsDeployment(sc *ocsv1.StorageCluster) error {
+// toolboxExecRules is the Role granted to toolboxExecSubjects so they can
+// exec into the rook-ceph-tools pod via cephcli.ToolboxExec.
+var toolboxExecRules = []rbacv1.PolicyRule{
+	{
+		APIGroups: []string{""},
+		Resources: []string{"pods", "pods/exec"},
+		Verbs:     []string{"get", "list", "create"},
+	},
+}
+
+// toolboxExecSubjects returns the ServiceAccount subjects granted exec
+// access into the rook-ceph-tools pod, for use as ensureToolsDeployment's
+// extraSubjects.
+func toolboxExecSubjects(namespace string) []rbacv1.Subject {
+	return []rbacv1.Subject{
+		{
+			Kind:      rbacv1.ServiceAccountKind,
+			Name:      ocsMetricsExporterServiceAccountName,
+			Namespace: namespace,
+		},
+	}
+}
+
+// ensureToolsDeployment reconciles the rook-ceph-tools deployment.
+// extraEnvVars, extraRules and extraSubjects let callers (e.g. the metrics
+// exporter, via cephcli.ToolboxExec) ask for the additional container env
+// and RBAC needed to treat the toolbox as their egress point for ceph admin
+// commands; extraSubjects is the ServiceAccount(s) the reconciled
+// Role/RoleBinding grants exec access to.
+func (r *StorageClusterReconciler) ensureToolsDeployment(sc *ocsv1.StorageCluster, extraEnvVars []corev1.EnvVar, extraRules []rbacv1.PolicyRule, extraSubjects []rbacv1.Subject) error {
 
 	var isFound bool
 	namespace := sc.Namespace
@@ -34,6 +78,19 @@ func (r *StorageClusterReconciler) ensureToolsDeployment(sc *ocsv1.StorageCluste
 	tolerations = append(tolerations, sc.Spec.ManagedResources.CephToolbox.Tolerations...)
 
 	toolsDeployment := sc.NewToolsDeployment(tolerations)
+	if len(extraEnvVars) > 0 {
+		for i := range toolsDeployment.Spec.Template.Spec.Containers {
+			toolsDeployment.Spec.Template.Spec.Containers[i].Env = append(
+				toolsDeployment.Spec.Template.Spec.Containers[i].Env, extraEnvVars...)
+		}
+	}
+
+	if len(extraRules) > 0 {
+		if err := r.ensureToolboxExecRBAC(sc, extraRules, extraSubjects); err != nil {
+			return err
+		}
+	}
+
 	foundToolsDeployment := &appsv1.Deployment{}
 	err := r.Client.Get(context.TODO(), types.NamespacedName{Name: rookCephToolDeploymentName, Namespace: namespace}, foundToolsDeployment)
 
@@ -76,3 +133,46 @@ func (r *StorageClusterReconciler) ensureToolsDeployment(sc *ocsv1.StorageCluste
 	}
 	return nil
 }
+
+// ensureToolboxExecRBAC reconciles the Role/RoleBinding that let
+// cephcli.ToolboxExec callers (e.g. the metrics exporter's ServiceAccount,
+// passed in as subjects) exec into the rook-ceph-tools pod. A Role without a
+// binding grants no access, so both are reconciled together.
+func (r *StorageClusterReconciler) ensureToolboxExecRBAC(sc *ocsv1.StorageCluster, extraRules []rbacv1.PolicyRule, subjects []rbacv1.Subject) error {
+	role := &rbacv1.Role{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      toolboxExecRoleName,
+			Namespace: sc.Namespace,
+		},
+	}
+
+	mutateFn := func() error {
+		role.Rules = extraRules
+		return controllerutil.SetControllerReference(sc, role, r.Client.Scheme())
+	}
+
+	_, err := controllerutil.CreateOrUpdate(context.TODO(), r.Client, role, mutateFn)
+	if err != nil {
+		return err
+	}
+
+	roleBinding := &rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      toolboxExecRoleBindingName,
+			Namespace: sc.Namespace,
+		},
+	}
+
+	bindingMutateFn := func() error {
+		roleBinding.Subjects = subjects
+		roleBinding.RoleRef = rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "Role",
+			Name:     toolboxExecRoleName,
+		}
+		return controllerutil.SetControllerReference(sc, roleBinding, r.Client.Scheme())
+	}
+
+	_, err = controllerutil.CreateOrUpdate(context.TODO(), r.Client, roleBinding, bindingMutateFn)
+	return err
+}
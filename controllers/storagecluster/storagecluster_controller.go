@@ -0,0 +1,50 @@
+package storagecluster
+
+import (
+	"context"
+
+	ocsv1 "github.com/red-hat-storage/ocs-operator/api/v1"
+	"github.com/red-hat-storage/ocs-operator/pkg/cephcli"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// StorageClusterReconciler reconciles a StorageCluster object.
+type StorageClusterReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+
+	// CephCLIExecutor is the single blessed egress for ceph admin commands
+	// (rbd, ceph) issued by this controller; it is either LocalExec or a
+	// ToolboxExec that execs into the reconciled rook-ceph-tools deployment.
+	CephCLIExecutor cephcli.CephCLIExecutor
+}
+
+// Reconcile is the entry point for the StorageCluster controller. It walks
+// through each reconcile stage for the StorageCluster, persisting Status
+// updates at the end.
+func (r *StorageClusterReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	sc := &ocsv1.StorageCluster{}
+	if err := r.Client.Get(ctx, req.NamespacedName, sc); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	if err := r.ensureToolsDeployment(sc, nil, toolboxExecRules, toolboxExecSubjects(sc.Namespace)); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if err := r.ensureTopologyDomainLabels(sc); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if err := r.ensureRBDMirrorPeer(sc); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if err := r.Client.Status().Update(ctx, sc); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{}, nil
+}
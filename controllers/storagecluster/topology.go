@@ -0,0 +1,265 @@
+package storagecluster
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	ocsv1 "github.com/red-hat-storage/ocs-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	// rookCephOperatorConfigName is the ConfigMap rook-ceph-operator reads
+	// its CSI driver flags from.
+	rookCephOperatorConfigName = "rook-ceph-operator-config"
+
+	// csiTopologyDomainLabelsConfigKey is the rook-ceph-operator-config key
+	// that controls the `--domainlabels` flag passed to the CSI provisioner
+	// and node plugin.
+	csiTopologyDomainLabelsConfigKey = "CSI_TOPOLOGY_DOMAIN_LABELS"
+
+	// workerNodeLabel is used to restrict the label-existence check in
+	// topologyDomainValues to worker nodes only.
+	workerNodeLabel = "node-role.kubernetes.io/worker"
+
+	rbdStorageClassName    = "ocs-storagecluster-ceph-rbd"
+	cephFSStorageClassName = "ocs-storagecluster-cephfs"
+)
+
+// ensureTopologyDomainLabels validates the topology labels requested on
+// Spec.CSI.TopologyDomainLabels against the worker nodes in the cluster and,
+// when all of them are present on at least one worker node, plumbs them into
+// the rook-ceph-operator config so the CSI provisioner and node plugin start
+// honoring zone/rack failure domains for RWO PVs, then brings the generated
+// RBD/CephFS StorageClasses in line with the requested topology mode.
+func (r *StorageClusterReconciler) ensureTopologyDomainLabels(sc *ocsv1.StorageCluster) error {
+	labels := sc.Spec.CSI.TopologyDomainLabels
+	if len(labels) == 0 {
+		return nil
+	}
+
+	domainValues, err := r.topologyDomainValues(labels)
+	if err != nil {
+		return err
+	}
+
+	rookCephOperatorConfig := &corev1.ConfigMap{}
+	err = r.Client.Get(context.TODO(), types.NamespacedName{Name: rookCephOperatorConfigName, Namespace: sc.Namespace}, rookCephOperatorConfig)
+	if err != nil {
+		return fmt.Errorf("failed to get %q configmap: %v", rookCephOperatorConfigName, err)
+	}
+
+	if rookCephOperatorConfig.Data == nil {
+		rookCephOperatorConfig.Data = map[string]string{}
+	}
+	rookCephOperatorConfig.Data[csiTopologyDomainLabelsConfigKey] = strings.Join(labels, ",")
+
+	if err := r.Client.Update(context.TODO(), rookCephOperatorConfig); err != nil {
+		return err
+	}
+
+	return r.ensureTopologyAwareStorageClasses(sc, domainValues)
+}
+
+// topologyDomainValues lists the worker nodes and, for every requested
+// topology label, collects the distinct values it actually carries. It
+// returns an error naming any label that is not present on any worker node,
+// since enabling topology mode for a failure domain the cluster cannot
+// satisfy would leave PVCs Pending forever.
+func (r *StorageClusterReconciler) topologyDomainValues(labels []string) (map[string][]string, error) {
+	nodeList := &corev1.NodeList{}
+	if err := r.Client.List(context.TODO(), nodeList); err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %v", err)
+	}
+
+	values := map[string]map[string]bool{}
+	for _, label := range labels {
+		values[label] = map[string]bool{}
+	}
+
+	for _, node := range nodeList.Items {
+		if _, isWorker := node.Labels[workerNodeLabel]; !isWorker {
+			continue
+		}
+		for _, label := range labels {
+			if v, ok := node.Labels[label]; ok {
+				values[label][v] = true
+			}
+		}
+	}
+
+	var missing []string
+	domainValues := map[string][]string{}
+	for _, label := range labels {
+		if len(values[label]) == 0 {
+			missing = append(missing, label)
+			continue
+		}
+		for v := range values[label] {
+			domainValues[label] = append(domainValues[label], v)
+		}
+	}
+
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("topology domain labels %v are not present on any worker node", missing)
+	}
+
+	return domainValues, nil
+}
+
+// allowedTopologiesFromLabels builds the storagev1.TopologySelectorTerm list
+// for a generated StorageClass, populating each label's Values with the
+// domain values actually discovered on worker nodes. An empty input yields
+// nil, leaving the StorageClass's topology/binding mode untouched.
+func allowedTopologiesFromLabels(domainValues map[string][]string, labels []string) []corev1.TopologySelectorTerm {
+	if len(labels) == 0 {
+		return nil
+	}
+
+	var expressions []corev1.TopologySelectorLabelRequirement
+	for _, label := range labels {
+		expressions = append(expressions, corev1.TopologySelectorLabelRequirement{
+			Key:    label,
+			Values: domainValues[label],
+		})
+	}
+
+	return []corev1.TopologySelectorTerm{{
+		MatchLabelExpressions: expressions,
+	}}
+}
+
+// applyTopologyDomainMode switches a generated StorageClass to
+// WaitForFirstConsumer binding with allowedTopologies built from the
+// discovered domain values, when topology mode is requested.
+func applyTopologyDomainMode(sc *ocsv1.StorageCluster, domainValues map[string][]string, storageClass *storagev1.StorageClass) {
+	labels := sc.Spec.CSI.TopologyDomainLabels
+	if len(labels) == 0 {
+		return
+	}
+
+	waitForFirstConsumer := storagev1.VolumeBindingWaitForFirstConsumer
+	storageClass.VolumeBindingMode = &waitForFirstConsumer
+	storageClass.AllowedTopologies = allowedTopologiesFromLabels(domainValues, labels)
+}
+
+// rbdStorageClassParameters builds the CSI parameters for the generated RBD
+// StorageClass, pointing the provisioner/node plugins at the StorageCluster's
+// own CephBlockPool and at the standard rook-csi-rbd-* Secrets rook-ceph
+// creates alongside it.
+func rbdStorageClassParameters(sc *ocsv1.StorageCluster) map[string]string {
+	return map[string]string{
+		"clusterID":     sc.Namespace,
+		"pool":          sc.Name + "-cephblockpool",
+		"imageFormat":   "2",
+		"imageFeatures": "layering",
+		"csi.storage.k8s.io/provisioner-secret-name":            "rook-csi-rbd-provisioner",
+		"csi.storage.k8s.io/provisioner-secret-namespace":       sc.Namespace,
+		"csi.storage.k8s.io/controller-expand-secret-name":      "rook-csi-rbd-provisioner",
+		"csi.storage.k8s.io/controller-expand-secret-namespace": sc.Namespace,
+		"csi.storage.k8s.io/node-stage-secret-name":             "rook-csi-rbd-node",
+		"csi.storage.k8s.io/node-stage-secret-namespace":        sc.Namespace,
+		"csi.storage.k8s.io/fstype":                             "ext4",
+	}
+}
+
+// cephFSStorageClassParameters builds the CSI parameters for the generated
+// CephFS StorageClass, pointing the provisioner/node plugins at the
+// StorageCluster's own CephFilesystem and at the standard
+// rook-csi-cephfs-* Secrets rook-ceph creates alongside it.
+func cephFSStorageClassParameters(sc *ocsv1.StorageCluster) map[string]string {
+	return map[string]string{
+		"clusterID": sc.Namespace,
+		"fsName":    sc.Name + "-cephfilesystem",
+		"csi.storage.k8s.io/provisioner-secret-name":            "rook-csi-cephfs-provisioner",
+		"csi.storage.k8s.io/provisioner-secret-namespace":       sc.Namespace,
+		"csi.storage.k8s.io/controller-expand-secret-name":      "rook-csi-cephfs-provisioner",
+		"csi.storage.k8s.io/controller-expand-secret-namespace": sc.Namespace,
+		"csi.storage.k8s.io/node-stage-secret-name":             "rook-csi-cephfs-node",
+		"csi.storage.k8s.io/node-stage-secret-namespace":        sc.Namespace,
+	}
+}
+
+// volumeBindingModeEqual compares two *storagev1.VolumeBindingMode by value,
+// treating two nil pointers as equal.
+func volumeBindingModeEqual(a, b *storagev1.VolumeBindingMode) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// ensureTopologyAwareStorageClasses reconciles the RBD and CephFS
+// StorageClasses this operator generates so they honor the requested
+// topology domains.
+func (r *StorageClusterReconciler) ensureTopologyAwareStorageClasses(sc *ocsv1.StorageCluster, domainValues map[string][]string) error {
+	provisionerPrefix := sc.Namespace
+	reclaimPolicy := corev1.PersistentVolumeReclaimDelete
+
+	storageClasses := []*storagev1.StorageClass{
+		{
+			ObjectMeta:    metav1.ObjectMeta{Name: rbdStorageClassName},
+			Provisioner:   provisionerPrefix + ".rbd.csi.ceph.com",
+			Parameters:    rbdStorageClassParameters(sc),
+			ReclaimPolicy: &reclaimPolicy,
+		},
+		{
+			ObjectMeta:    metav1.ObjectMeta{Name: cephFSStorageClassName},
+			Provisioner:   provisionerPrefix + ".cephfs.csi.ceph.com",
+			Parameters:    cephFSStorageClassParameters(sc),
+			ReclaimPolicy: &reclaimPolicy,
+		},
+	}
+
+	for _, storageClass := range storageClasses {
+		applyTopologyDomainMode(sc, domainValues, storageClass)
+
+		found := &storagev1.StorageClass{}
+		err := r.Client.Get(context.TODO(), types.NamespacedName{Name: storageClass.Name}, found)
+		if err != nil {
+			if !errors.IsNotFound(err) {
+				return err
+			}
+			if err := controllerutil.SetControllerReference(sc, storageClass, r.Scheme); err != nil {
+				return err
+			}
+			if err := r.Client.Create(context.TODO(), storageClass); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if volumeBindingModeEqual(found.VolumeBindingMode, storageClass.VolumeBindingMode) &&
+			reflect.DeepEqual(found.AllowedTopologies, storageClass.AllowedTopologies) {
+			continue
+		}
+
+		// VolumeBindingMode and AllowedTopologies are immutable once a
+		// StorageClass exists, so the API server rejects an Update that
+		// changes either. Switching topology mode on an existing SC requires
+		// deleting and recreating it instead; carry over the existing
+		// Parameters/ReclaimPolicy rather than the freshly generated ones, in
+		// case they were customized after creation.
+		storageClass.Parameters = found.Parameters
+		storageClass.ReclaimPolicy = found.ReclaimPolicy
+
+		if err := r.Client.Delete(context.TODO(), found); err != nil {
+			return err
+		}
+		if err := controllerutil.SetControllerReference(sc, storageClass, r.Scheme); err != nil {
+			return err
+		}
+		if err := r.Client.Create(context.TODO(), storageClass); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,54 @@
+package cache
+
+import "os"
+
+const (
+	cephConfigRoot = "/etc/ceph"
+	cephConfigPath = "/etc/ceph/ceph.conf"
+	keyRing        = "/etc/ceph/keyring"
+)
+
+var cephConfig = []byte(`[global]
+auth_cluster_required = cephx
+auth_service_required = cephx
+auth_client_required = cephx
+`)
+
+/*
+	Copied from https://github.com/ceph/ceph-csi/blob/70fc6db2cfe3f00945c030f0d7f83ea1e2d21a00/internal/util/cephconf.go
+	Functions to create ceph.conf and keyring files internally.
+*/
+
+func createCephConfigRoot() error {
+	return os.MkdirAll(cephConfigRoot, 0o755)
+}
+
+// createKeyRingFile creates the keyring files to fix above error message logging.
+func createKeyRingFile() error {
+	var err error
+	if _, err = os.Stat(keyRing); os.IsNotExist(err) {
+		_, err = os.Create(keyRing)
+	}
+
+	return err
+}
+
+// writeCephConfig writes out a basic ceph.conf file, making it easy to use
+// ceph related CLIs. Shared by any mirror store (RBD, CephFS) that still
+// shells out to a ceph CLI tool.
+func writeCephConfig() error {
+	var err error
+	if err = createCephConfigRoot(); err != nil {
+		return err
+	}
+
+	if _, err = os.Stat(cephConfigPath); os.IsNotExist(err) {
+		err = os.WriteFile(cephConfigPath, cephConfig, 0o600)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return createKeyRingFile()
+}
@@ -0,0 +1,415 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/red-hat-storage/ocs-operator/metrics/internal/options"
+	"github.com/red-hat-storage/ocs-operator/pkg/cephcli"
+	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
+	rookclient "github.com/rook/rook/pkg/client/clientset/versioned"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+)
+
+// CephFSMirrorStatusVerbose is a single mirror daemon's entry in `ceph fs
+// snapshot mirror daemon status`, listing every filesystem it mirrors.
+type CephFSMirrorStatusVerbose struct {
+	DaemonID    int                            `json:"daemon_id"`
+	Filesystems []CephFSMirrorFilesystemStatus `json:"filesystems"`
+}
+
+// CephFSMirrorFilesystemStatus is a single filesystem entry within the
+// daemon status output.
+type CephFSMirrorFilesystemStatus struct {
+	FilesystemID int                      `json:"filesystem_id"`
+	Name         string                   `json:"name"`
+	Peers        []CephFSMirrorPeerStatus `json:"peers"`
+}
+
+// CephFSMirrorPeerStatus is the replication status of a single peer for a
+// mirrored CephFS filesystem, as reported by `ceph fs snapshot mirror
+// daemon status`. The sync counters live under the peer's own "stats"
+// object in that output, not in `peer_list` (which only carries peer
+// identity, not replication progress). Directories is populated separately
+// from `ceph fs snapshot mirror peer status`, since daemon status itself
+// only reports the filesystem-wide aggregate.
+type CephFSMirrorPeerStatus struct {
+	PeerUUID              string                 `json:"uuid"`
+	Remote                CephFSMirrorPeerRemote `json:"remote"`
+	CephFSMirrorPeerStats `json:"stats"`
+	Directories           map[string]CephFSMirrorDirectoryStatus `json:"-"`
+}
+
+// CephFSMirrorPeerRemote identifies the remote cluster/filesystem a peer
+// mirrors to.
+type CephFSMirrorPeerRemote struct {
+	ClientName  string `json:"client_name"`
+	ClusterName string `json:"cluster_name"`
+	FsName      string `json:"fs_name"`
+}
+
+// CephFSMirrorPeerStats is the per-peer replication progress nested under
+// "stats" in the daemon status output.
+type CephFSMirrorPeerStats struct {
+	SnapsSynced    int     `json:"snaps_synced"`
+	SnapsDeleted   int     `json:"snaps_deleted"`
+	SnapsRenamed   int     `json:"snaps_renamed"`
+	SyncBytes      int64   `json:"sync_bytes"`
+	SyncDuration   float64 `json:"sync_duration"`
+	LastSyncedSnap string  `json:"last_synced_snap"`
+}
+
+// CephFSMirrorDirectoryStatus is a single mirrored directory's sync
+// progress for one peer, as reported by `ceph fs snapshot mirror peer
+// status`, which (unlike daemon status) breaks results down per directory
+// path rather than aggregating them for the whole filesystem.
+type CephFSMirrorDirectoryStatus struct {
+	State          string                     `json:"state"`
+	LastSyncedSnap CephFSMirrorLastSyncedSnap `json:"last_synced_snap"`
+	SnapsSynced    int                        `json:"snaps_synced"`
+	SnapsDeleted   int                        `json:"snaps_deleted"`
+	SnapsRenamed   int                        `json:"snaps_renamed"`
+}
+
+// CephFSMirrorLastSyncedSnap describes the most recent snapshot synced for
+// a directory.
+type CephFSMirrorLastSyncedSnap struct {
+	ID            int     `json:"id"`
+	Name          string  `json:"name"`
+	SyncDuration  float64 `json:"sync_duration"`
+	SyncTimeStamp string  `json:"sync_time_stamp"`
+	SyncBytes     int64   `json:"sync_bytes"`
+}
+
+type CephFSMirrorPoolStatusVerbose struct {
+	FilesystemName string
+	Namespace      string
+	MirrorStatus   CephFSMirrorStatusVerbose
+}
+
+var _ cache.Store = &CephFSMirrorStore{}
+
+// CephFSMirrorStore implements the k8s.io/client-go/tools/cache.Store
+// interface. It stores CephFS subvolume mirror/replication data, mirroring
+// the design of RBDMirrorStore.
+type CephFSMirrorStore struct {
+	Mutex sync.RWMutex
+	// Store is a map of Filesystem UID to CephFSMirrorPoolStatusVerbose
+	Store map[types.UID]CephFSMirrorPoolStatusVerbose
+	// cephfsCommandInput is a struct that contains the input for the ceph
+	// command for each AllowedNamespaces
+	cephfsCommandInput map[string]*cephfsCommandInput
+	kubeclient         clientset.Interface
+	allowedNamespaces  []string
+	executor           cephcli.CephCLIExecutor
+}
+
+func NewCephFSMirrorStore(opts *options.Options) *CephFSMirrorStore {
+	executor := cephcli.NewExecutor(opts.UseToolboxExec, opts.Kubeconfig, clientset.NewForConfigOrDie(opts.Kubeconfig), opts.Namespace)
+
+	if _, ok := executor.(cephcli.LocalExec); ok {
+		// write Ceph config file before issuing ceph fs snapshot mirror commands
+		err := writeCephConfig()
+		if err != nil {
+			// With the current implementation, this is not possible.
+			panic(err)
+		}
+	}
+
+	return &CephFSMirrorStore{
+		Store:              map[types.UID]CephFSMirrorPoolStatusVerbose{},
+		cephfsCommandInput: map[string]*cephfsCommandInput{},
+		kubeclient:         clientset.NewForConfigOrDie(opts.Kubeconfig),
+		allowedNamespaces:  opts.AllowedNamespaces,
+		executor:           executor,
+	}
+}
+
+// WithCephFSCommandInput bootstraps the monitor/credentials needed to reach
+// a namespace's Ceph cluster, following the same rook-ceph-mon Secret and
+// rook-ceph-csi-config ConfigMap lookup as RBDMirrorStore.WithRBDCommandInput.
+func (s *CephFSMirrorStore) WithCephFSCommandInput(namespace string) error {
+	var allow bool
+	for _, item := range s.allowedNamespaces {
+		if item == namespace {
+			allow = true
+			break
+		}
+	}
+	if !allow {
+		return fmt.Errorf("cephfs-mirror metrics collection from namespace %q is not allowed", namespace)
+	}
+
+	secret, err := s.kubeclient.CoreV1().Secrets(namespace).Get(context.TODO(), "rook-ceph-mon", metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get secret in namespace %q: %v", namespace, err)
+	}
+	key, ok := secret.Data["ceph-secret"]
+	if !ok {
+		return fmt.Errorf("failed to get client key from secret in namespace %q", namespace)
+	}
+	id := "admin"
+
+	configmap, err := s.kubeclient.CoreV1().ConfigMaps(namespace).Get(context.TODO(), "rook-ceph-csi-config", metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get configmap in namespace %q: %v", namespace, err)
+	}
+
+	data, ok := configmap.Data["csi-cluster-config-json"]
+	if !ok {
+		return fmt.Errorf("failed to get CSI cluster config from configmap in namespace %q", namespace)
+	}
+
+	var clusterConfig []csiClusterConfig
+	err = json.Unmarshal([]byte(data), &clusterConfig)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal csi-cluster-config-json in namespace %q: %v", namespace, err)
+	}
+
+	if len(clusterConfig) == 0 {
+		return fmt.Errorf("expected 1 or more CSI cluster config but found 0 from configmap in namespace %q", namespace)
+	}
+	if len(clusterConfig[0].Monitors) == 0 {
+		return fmt.Errorf("expected 1 or more monitors but found 0 from configmap in namespace %q", namespace)
+	}
+
+	input := cephfsCommandInput{}
+	input.monitor = clusterConfig[0].Monitors[0]
+	input.id = id
+	input.key = string(key)
+	input.executor = s.executor
+	s.cephfsCommandInput[namespace] = &input
+
+	return nil
+}
+
+func (s *CephFSMirrorStore) Add(obj interface{}) error {
+	o, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+
+	fs, ok := obj.(*cephv1.CephFilesystem)
+	if !ok {
+		return fmt.Errorf("unexpected object of type %T", obj)
+	}
+
+	if !fs.Spec.Mirroring.Enabled {
+		klog.Infof("skipping cephfs mirror status update for filesystem %s/%s because mirroring is disabled", fs.Namespace, fs.Name)
+		return nil
+	}
+
+	if _, ok := s.cephfsCommandInput[fs.Namespace]; !ok {
+		err := s.WithCephFSCommandInput(fs.Namespace)
+		if err != nil {
+			klog.Errorf("Failed to initialize cephfs command input for filesystem %s/%s: %v", fs.Namespace, fs.Name, err)
+			return fmt.Errorf("cephfs command error for filesystem %s/%s : %v", fs.Namespace, fs.Name, err)
+		}
+	}
+
+	mirrorStatus, err := s.cephfsCommandInput[fs.Namespace].cephfsMirrorStatus()
+	if err != nil {
+		return fmt.Errorf("ceph fs snapshot mirror command error: %v", err)
+	}
+
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+
+	s.Store[o.GetUID()] = CephFSMirrorPoolStatusVerbose{
+		FilesystemName: fs.Name,
+		Namespace:      fs.Namespace,
+		MirrorStatus:   mirrorStatus,
+	}
+
+	return nil
+}
+
+func (s *CephFSMirrorStore) Update(obj interface{}) error {
+	return s.Add(obj)
+}
+
+func (s *CephFSMirrorStore) Delete(obj interface{}) error {
+	o, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+
+	delete(s.Store, o.GetUID())
+
+	return nil
+}
+
+func (s *CephFSMirrorStore) List() []interface{} {
+	return nil
+}
+
+func (s *CephFSMirrorStore) ListKeys() []string {
+	return nil
+}
+
+func (s *CephFSMirrorStore) Get(obj interface{}) (item interface{}, exists bool, err error) {
+	return nil, false, nil
+}
+
+func (s *CephFSMirrorStore) GetByKey(key string) (item interface{}, exists bool, err error) {
+	return nil, false, nil
+}
+
+func (s *CephFSMirrorStore) Replace(list []interface{}, _ string) error {
+	s.Mutex.Lock()
+	s.Store = map[types.UID]CephFSMirrorPoolStatusVerbose{}
+	s.Mutex.Unlock()
+
+	for _, o := range list {
+		err := s.Add(o)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Resync re-runs the ceph fs snapshot mirror commands for every cached
+// filesystem. It only holds the read lock while copying out the current
+// entries and the write lock while writing the refreshed ones back, not across the ceph
+// CLI calls themselves (one daemon status call plus one peer status call
+// per peer), so a slow or large resync batch doesn't stall Collect's
+// RLock for its whole duration.
+func (s *CephFSMirrorStore) Resync() error {
+	klog.Infof("CephFS mirror store resync started at %v", time.Now())
+
+	s.Mutex.RLock()
+	entries := make(map[types.UID]CephFSMirrorPoolStatusVerbose, len(s.Store))
+	for fsUUID, fsStatusVerbose := range s.Store {
+		entries[fsUUID] = fsStatusVerbose
+	}
+	s.Mutex.RUnlock()
+
+	for fsUUID, fsStatusVerbose := range entries {
+		if _, ok := s.cephfsCommandInput[fsStatusVerbose.Namespace]; !ok {
+			err := s.WithCephFSCommandInput(fsStatusVerbose.Namespace)
+			if err != nil {
+				klog.Errorf("Failed to initialize cephfs command input for filesystem %s/%s: %v", fsStatusVerbose.Namespace, fsStatusVerbose.FilesystemName, err)
+				continue
+			}
+		}
+
+		mirrorStatus, err := s.cephfsCommandInput[fsStatusVerbose.Namespace].cephfsMirrorStatus()
+		if err != nil {
+			klog.Errorf("ceph fs snapshot mirror command error: %v", err)
+			continue
+		}
+
+		s.Mutex.Lock()
+		s.Store[fsUUID] = CephFSMirrorPoolStatusVerbose{
+			FilesystemName: fsStatusVerbose.FilesystemName,
+			Namespace:      fsStatusVerbose.Namespace,
+			MirrorStatus:   mirrorStatus,
+		}
+		s.Mutex.Unlock()
+	}
+	klog.Infof("CephFS mirror store resync ended at %v", time.Now())
+	return nil
+}
+
+func CreateCephFilesystemListWatch(cephClient rookclient.Interface, namespace, fieldSelector string) cache.ListerWatcher {
+	return &cache.ListWatch{
+		ListFunc: func(opts metav1.ListOptions) (runtime.Object, error) {
+			opts.FieldSelector = fieldSelector
+			return cephClient.CephV1().CephFilesystems(namespace).List(context.TODO(), opts)
+		},
+		WatchFunc: func(opts metav1.ListOptions) (watch.Interface, error) {
+			opts.FieldSelector = fieldSelector
+			return cephClient.CephV1().CephFilesystems(namespace).Watch(context.TODO(), opts)
+		},
+	}
+}
+
+/* ceph fs snapshot mirror CLI Commands */
+
+type cephfsCommandInput struct {
+	monitor, id, key string
+	executor         cephcli.CephCLIExecutor
+}
+
+// cephfsMirrorStatus runs `ceph fs snapshot mirror daemon status` and keeps
+// the first daemon's entry, which lists every filesystem that daemon
+// mirrors (not only the one being added), then fills in each peer's Directories from `ceph fs snapshot
+// mirror peer status`. The sync counters (snaps_synced, sync_bytes, ...) on
+// the peer itself are read straight from its nested "stats" object in the
+// daemon status output, since `peer_list` only reports peer identity
+// (uuid/site/fs), not replication progress; daemon status only aggregates
+// that progress for the whole filesystem, so the per-directory breakdown
+// has to come from a second command, per peer. The commands are dispatched
+// through the store's cephcli.CephCLIExecutor, so this works whether that
+// executor runs locally or in the rook-ceph-tools toolbox.
+func (in *cephfsCommandInput) cephfsMirrorStatus() (CephFSMirrorStatusVerbose, error) {
+	var status CephFSMirrorStatusVerbose
+
+	if in.monitor == "" && in.id == "" && in.key == "" {
+		return status, errors.New("unable to get CephFS mirror data. CephFS command input not specified")
+	}
+
+	daemonStatusArgs := []string{"fs", "snapshot", "mirror", "daemon", "status", "--format", "json", "-m", in.monitor, "--id", in.id, "--key", in.key}
+	out, err := in.executor.Execute(context.TODO(), "ceph", daemonStatusArgs)
+	if err != nil {
+		return status, err
+	}
+
+	var daemonStatus []CephFSMirrorStatusVerbose
+	if err := json.Unmarshal(out, &daemonStatus); err != nil {
+		return status, err
+	}
+	if len(daemonStatus) == 0 {
+		return status, nil
+	}
+	status = daemonStatus[0]
+
+	for fi := range status.Filesystems {
+		fs := &status.Filesystems[fi]
+		for pi := range fs.Peers {
+			peer := &fs.Peers[pi]
+			directories, err := in.cephfsMirrorPeerDirectoryStatus(fs.Name, fs.FilesystemID, peer.PeerUUID)
+			if err != nil {
+				klog.Errorf("failed to get per-directory mirror status for filesystem %q peer %q: %v", fs.Name, peer.PeerUUID, err)
+				continue
+			}
+			peer.Directories = directories
+		}
+	}
+
+	return status, nil
+}
+
+// cephfsMirrorPeerDirectoryStatus runs `ceph fs snapshot mirror peer
+// status` for a single peer, which reports sync progress per mirrored
+// directory path, unlike daemon status's filesystem-wide aggregate.
+func (in *cephfsCommandInput) cephfsMirrorPeerDirectoryStatus(fsName string, fsID int, peerUUID string) (map[string]CephFSMirrorDirectoryStatus, error) {
+	args := []string{"fs", "snapshot", "mirror", "peer", "status", fmt.Sprintf("%s@%d", fsName, fsID), peerUUID, "--format", "json", "-m", in.monitor, "--id", in.id, "--key", in.key}
+	out, err := in.executor.Execute(context.TODO(), "ceph", args)
+	if err != nil {
+		return nil, err
+	}
+
+	var directories map[string]CephFSMirrorDirectoryStatus
+	if err := json.Unmarshal(out, &directories); err != nil {
+		return nil, err
+	}
+
+	return directories, nil
+}
@@ -5,12 +5,12 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"os"
-	"os/exec"
+	"path/filepath"
 	"sync"
 	"time"
 
 	"github.com/red-hat-storage/ocs-operator/metrics/internal/options"
+	"github.com/red-hat-storage/ocs-operator/pkg/cephcli"
 	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
 	rookclient "github.com/rook/rook/pkg/client/clientset/versioned"
 	"k8s.io/apimachinery/pkg/api/meta"
@@ -21,6 +21,7 @@ import (
 	"k8s.io/apimachinery/pkg/watch"
 	clientset "k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog"
 )
 
@@ -95,18 +96,6 @@ type RBDMirrorPeerSiteDescription struct {
 	ReplayState             string  `json:"replay_state"`
 }
 
-const (
-	cephConfigRoot = "/etc/ceph"
-	cephConfigPath = "/etc/ceph/ceph.conf"
-	keyRing        = "/etc/ceph/keyring"
-)
-
-var cephConfig = []byte(`[global]
-auth_cluster_required = cephx
-auth_service_required = cephx
-auth_client_required = cephx
-`)
-
 type csiClusterConfig struct {
 	ClusterID string   `json:"clusterID"`
 	Monitors  []string `json:"monitors"`
@@ -116,34 +105,137 @@ type csiClusterConfig struct {
 
 var _ cache.Store = &RBDMirrorStore{}
 
+// storeShardCount is the number of independent RWMutex shards the store's
+// per-pool entries are spread across, so that an update to one pool never
+// blocks a collector reading a different one.
+const storeShardCount = 32
+
 // RBDMirrorStore implements the k8s.io/client-go/tools/cache.Store
 // interface. It stores rbd mirror data.
 type RBDMirrorStore struct {
-	Mutex sync.RWMutex
+	shards [storeShardCount]sync.RWMutex
 	// Store is a map of Pool UID to RBDMirrorPoolStatusVerbose
 	Store map[types.UID]RBDMirrorPoolStatusVerbose
-	// rbdCommandInput is a struct that contains the input for the rbd command
-	// for each AllowdNamespaces
+	// rbdCommandInput is a struct that contains the executor and
+	// credentials used to talk to a given namespace's Ceph cluster
 	rbdCommandInput   map[string]*rbdCommandInput
+	rbdCommandInputMu sync.RWMutex
 	kubeclient        clientset.Interface
 	allowedNamespaces []string
+	executor          cephcli.CephCLIExecutor
+
+	// pools holds the latest known CephBlockPool for every "namespace/name"
+	// key the queue below references. The queue itself only ever carries
+	// that string key, so bursts of Add/Update events for the same pool
+	// collapse into the single pending entry the workqueue's dirty-set
+	// already dedupes, instead of one queue entry per distinct pointer.
+	poolsMu sync.RWMutex
+	pools   map[string]*cephv1.CephBlockPool
+
+	queue         workqueue.RateLimitingInterface
+	debounceDelay time.Duration
+	wg            sync.WaitGroup
 }
 
 func NewRBDMirrorStore(opts *options.Options) *RBDMirrorStore {
-	// write Ceph config file before issuing RBD mirror commands
-	err := writeCephConfig()
-	if err != nil {
-		// With the current implementation, this is not possible.
-		panic(err)
+	workerPoolSize := opts.RBDMirrorWorkerPoolSize
+	if workerPoolSize <= 0 {
+		workerPoolSize = 4
+	}
+
+	debounceDelay := opts.RBDMirrorDebounceWindow
+	if debounceDelay <= 0 {
+		debounceDelay = 5 * time.Second
 	}
-	return &RBDMirrorStore{
+
+	executor := cephcli.NewExecutor(opts.UseToolboxExec, opts.Kubeconfig, clientset.NewForConfigOrDie(opts.Kubeconfig), opts.Namespace)
+
+	if _, ok := executor.(cephcli.LocalExec); ok {
+		// write Ceph config file before issuing rbd mirror commands
+		err := writeCephConfig()
+		if err != nil {
+			// With the current implementation, this is not possible.
+			panic(err)
+		}
+	}
+
+	s := &RBDMirrorStore{
 		Store:             map[types.UID]RBDMirrorPoolStatusVerbose{},
 		rbdCommandInput:   map[string]*rbdCommandInput{},
 		kubeclient:        clientset.NewForConfigOrDie(opts.Kubeconfig),
 		allowedNamespaces: opts.AllowedNamespaces,
+		executor:          executor,
+		pools:             map[string]*cephv1.CephBlockPool{},
+		queue:             workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "rbdmirror"),
+		debounceDelay:     debounceDelay,
+	}
+
+	for i := 0; i < workerPoolSize; i++ {
+		s.wg.Add(1)
+		go s.runWorker()
+	}
+
+	return s
+}
+
+// Stop shuts the debounce queue down and waits for every worker to drain,
+// so callers can cleanly tear the store down (e.g. in tests).
+func (s *RBDMirrorStore) Stop() {
+	s.queue.ShutDown()
+	s.wg.Wait()
+}
+
+func poolKey(namespace, name string) string {
+	return namespace + "/" + name
+}
+
+func (s *RBDMirrorStore) shardFor(uid types.UID) *sync.RWMutex {
+	var h uint32
+	for i := 0; i < len(uid); i++ {
+		h = h*31 + uint32(uid[i])
+	}
+	return &s.shards[h%storeShardCount]
+}
+
+// runWorker drains the debounce queue, coalescing repeated enqueues of the
+// same pool key into a single rbdImageStatus refresh.
+func (s *RBDMirrorStore) runWorker() {
+	defer s.wg.Done()
+	for {
+		item, shutdown := s.queue.Get()
+		if shutdown {
+			return
+		}
+
+		key := item.(string)
+		s.processKey(key)
+		s.queue.Done(item)
 	}
 }
 
+func (s *RBDMirrorStore) processKey(key string) {
+	pool := s.poolForKey(key)
+	if pool == nil {
+		// Deleted before the debounce delay elapsed; nothing to refresh.
+		s.queue.Forget(key)
+		return
+	}
+
+	if err := s.refresh(pool); err != nil {
+		klog.Errorf("rbd mirror status refresh failed for pool %s: %v", key, err)
+		s.queue.AddRateLimited(key)
+		return
+	}
+
+	s.queue.Forget(key)
+}
+
+func (s *RBDMirrorStore) poolForKey(key string) *cephv1.CephBlockPool {
+	s.poolsMu.RLock()
+	defer s.poolsMu.RUnlock()
+	return s.pools[key]
+}
+
 func (s *RBDMirrorStore) WithRBDCommandInput(namespace string) error {
 	var allow bool
 	for _, item := range s.allowedNamespaces {
@@ -189,21 +281,41 @@ func (s *RBDMirrorStore) WithRBDCommandInput(namespace string) error {
 		return fmt.Errorf("expected 1 or more monitors but found 0 from configmap in namespace %q", namespace)
 	}
 
-	input := rbdCommandInput{}
-	input.monitor = clusterConfig[0].Monitors[0]
-	input.id = id
-	input.key = string(key)
-	s.rbdCommandInput[namespace] = &input
+	keyringPath := rbdMirrorKeyringPath(namespace, id)
+	keyringContent := []byte(fmt.Sprintf("[client.%s]\n\tkey = %s\n", id, string(key)))
+	if err := s.executor.WriteFile(context.TODO(), keyringPath, keyringContent); err != nil {
+		return fmt.Errorf("failed to stage keyring for namespace %q: %v", namespace, err)
+	}
+
+	input := &rbdCommandInput{
+		monitor:     clusterConfig[0].Monitors[0],
+		id:          id,
+		keyringPath: keyringPath,
+		executor:    s.executor,
+	}
+
+	s.rbdCommandInputMu.Lock()
+	s.rbdCommandInput[namespace] = input
+	s.rbdCommandInputMu.Unlock()
 
 	return nil
 }
 
-func (s *RBDMirrorStore) Add(obj interface{}) error {
-	o, err := meta.Accessor(obj)
-	if err != nil {
-		return err
-	}
+// rbdMirrorKeyringPath is where the per-namespace admin keyring is staged
+// (locally, or inside the toolbox pod) so rbdImageStatus can point `rbd` at
+// it with --keyring instead of putting the key itself on the command line.
+func rbdMirrorKeyringPath(namespace, id string) string {
+	return filepath.Join(cephConfigRoot, fmt.Sprintf("keyring-rbd-mirror-%s-%s", namespace, id))
+}
 
+func (s *RBDMirrorStore) commandInputFor(namespace string) (*rbdCommandInput, bool) {
+	s.rbdCommandInputMu.RLock()
+	defer s.rbdCommandInputMu.RUnlock()
+	in, ok := s.rbdCommandInput[namespace]
+	return in, ok
+}
+
+func (s *RBDMirrorStore) Add(obj interface{}) error {
 	pool, ok := obj.(*cephv1.CephBlockPool)
 	if !ok {
 		return fmt.Errorf("unexpected object of type %T", obj)
@@ -214,21 +326,47 @@ func (s *RBDMirrorStore) Add(obj interface{}) error {
 		return nil
 	}
 
-	if _, ok := s.rbdCommandInput[pool.Namespace]; !ok {
-		err := s.WithRBDCommandInput(pool.Namespace)
-		if err != nil {
-			klog.Errorf("Failed to initialize rbd command input for pool %s/%s: %v", pool.Namespace, pool.Name, err)
+	key := poolKey(pool.Namespace, pool.Name)
+
+	s.poolsMu.Lock()
+	s.pools[key] = pool
+	s.poolsMu.Unlock()
+
+	// AddAfter, keyed by the (comparable) "namespace/name" string rather
+	// than the *CephBlockPool pointer: the workqueue's dirty set dedupes
+	// by key equality, so a burst of Add/Update events for the same pool
+	// within the debounce window collapses into a single queued entry
+	// instead of one per distinct pointer.
+	s.queue.AddAfter(key, s.debounceDelay)
+
+	return nil
+}
+
+func (s *RBDMirrorStore) Update(obj interface{}) error {
+	return s.Add(obj)
+}
+
+func (s *RBDMirrorStore) refresh(pool *cephv1.CephBlockPool) error {
+	if _, ok := s.commandInputFor(pool.Namespace); !ok {
+		if err := s.WithRBDCommandInput(pool.Namespace); err != nil {
 			return fmt.Errorf("rbd command error for pool %s/%s : %v", pool.Namespace, pool.Name, err)
 		}
 	}
 
-	mirrorStatus, err := s.rbdCommandInput[pool.Namespace].rbdImageStatus(pool.Name)
+	in, _ := s.commandInputFor(pool.Namespace)
+	mirrorStatus, err := in.rbdImageStatus(pool.Name)
 	if err != nil {
 		return fmt.Errorf("rbd command error: %v", err)
 	}
 
-	s.Mutex.Lock()
-	defer s.Mutex.Unlock()
+	o, err := meta.Accessor(pool)
+	if err != nil {
+		return err
+	}
+
+	lock := s.shardFor(o.GetUID())
+	lock.Lock()
+	defer lock.Unlock()
 
 	s.Store[o.GetUID()] = RBDMirrorPoolStatusVerbose{
 		PoolName:      pool.Name,
@@ -239,18 +377,22 @@ func (s *RBDMirrorStore) Add(obj interface{}) error {
 	return nil
 }
 
-func (s *RBDMirrorStore) Update(obj interface{}) error {
-	return s.Add(obj)
-}
-
 func (s *RBDMirrorStore) Delete(obj interface{}) error {
 	o, err := meta.Accessor(obj)
 	if err != nil {
 		return err
 	}
 
-	s.Mutex.Lock()
-	defer s.Mutex.Unlock()
+	if pool, ok := obj.(*cephv1.CephBlockPool); ok {
+		key := poolKey(pool.Namespace, pool.Name)
+		s.poolsMu.Lock()
+		delete(s.pools, key)
+		s.poolsMu.Unlock()
+	}
+
+	lock := s.shardFor(o.GetUID())
+	lock.Lock()
+	defer lock.Unlock()
 
 	delete(s.Store, o.GetUID())
 
@@ -274,9 +416,13 @@ func (s *RBDMirrorStore) GetByKey(key string) (item interface{}, exists bool, er
 }
 
 func (s *RBDMirrorStore) Replace(list []interface{}, _ string) error {
-	s.Mutex.Lock()
+	for i := 0; i < storeShardCount; i++ {
+		s.shards[i].Lock()
+	}
 	s.Store = map[types.UID]RBDMirrorPoolStatusVerbose{}
-	s.Mutex.Unlock()
+	for i := 0; i < storeShardCount; i++ {
+		s.shards[i].Unlock()
+	}
 
 	for _, o := range list {
 		err := s.Add(o)
@@ -288,36 +434,49 @@ func (s *RBDMirrorStore) Replace(list []interface{}, _ string) error {
 	return nil
 }
 
+// Resync re-enqueues every pool Add already cached, keyed the same way as
+// Add, so refresh looks up the real *cephv1.CephBlockPool (with its real
+// UID) rather than a synthetic stand-in. Building a fresh CephBlockPool
+// here instead would carry an empty UID, and every resynced pool would
+// collapse onto the same Store[""] entry.
 func (s *RBDMirrorStore) Resync() error {
 	klog.Infof("RBD mirror store resync started at %v", time.Now())
-	s.Mutex.Lock()
-	defer s.Mutex.Unlock()
-
-	for poolUUID, poolStatusVerbose := range s.Store {
-		if _, ok := s.rbdCommandInput[poolStatusVerbose.PoolNamespace]; !ok {
-			err := s.WithRBDCommandInput(poolStatusVerbose.PoolNamespace)
-			if err != nil {
-				klog.Errorf("Failed to initialize rbd command input for pool %s/%s: %v", poolStatusVerbose.PoolNamespace, poolStatusVerbose.PoolName, err)
-				continue
-			}
-		}
 
-		mirrorStatus, err := s.rbdCommandInput[poolStatusVerbose.PoolNamespace].rbdImageStatus(poolStatusVerbose.PoolName)
-		if err != nil {
-			klog.Errorf("rbd command error: %v", err)
-			continue
-		}
+	s.poolsMu.RLock()
+	keys := make([]string, 0, len(s.pools))
+	for key := range s.pools {
+		keys = append(keys, key)
+	}
+	s.poolsMu.RUnlock()
 
-		s.Store[poolUUID] = RBDMirrorPoolStatusVerbose{
-			PoolName:      poolStatusVerbose.PoolName,
-			PoolNamespace: poolStatusVerbose.PoolNamespace,
-			MirrorStatus:  mirrorStatus,
-		}
+	for _, key := range keys {
+		s.queue.Add(key)
 	}
-	klog.Infof("RBD mirror store resync ended at %v", time.Now())
+
+	klog.Infof("RBD mirror store resync enqueued %d pools at %v", len(keys), time.Now())
 	return nil
 }
 
+// Snapshot returns a point-in-time copy of every pool's cached mirror
+// status. Collectors should use this instead of reaching into Store
+// directly, since the store is sharded across several locks internally.
+func (s *RBDMirrorStore) Snapshot() []RBDMirrorPoolStatusVerbose {
+	for i := 0; i < storeShardCount; i++ {
+		s.shards[i].RLock()
+	}
+	defer func() {
+		for i := 0; i < storeShardCount; i++ {
+			s.shards[i].RUnlock()
+		}
+	}()
+
+	pools := make([]RBDMirrorPoolStatusVerbose, 0, len(s.Store))
+	for _, p := range s.Store {
+		pools = append(pools, p)
+	}
+	return pools
+}
+
 func CreateCephBlockPoolListWatch(cephClient rookclient.Interface, namespace, fieldSelector string) cache.ListerWatcher {
 	return &cache.ListWatch{
 		ListFunc: func(opts metav1.ListOptions) (runtime.Object, error) {
@@ -331,70 +490,39 @@ func CreateCephBlockPoolListWatch(cephClient rookclient.Interface, namespace, fi
 	}
 }
 
-/* RBD CLI Commands */
+/* rbd mirror pool status CLI command */
 
+// rbdCommandInput holds the credentials needed to reach a namespace's Ceph
+// cluster and the executor rbdImageStatus dispatches `rbd` commands through,
+// so the store never needs ceph client binaries or admin credentials of its
+// own when running against the toolbox. The admin key itself is staged in a
+// keyringPath file rather than kept here for use on a command line.
 type rbdCommandInput struct {
-	monitor, id, key string
+	monitor, id string
+	keyringPath string
+	executor    cephcli.CephCLIExecutor
 }
 
+// rbdImageStatus runs `rbd mirror pool status --verbose --format json` for
+// the pool. The command is dispatched through the store's
+// cephcli.CephCLIExecutor, so this works whether that executor runs it
+// locally or in the rook-ceph-tools toolbox. It points `rbd` at the staged
+// keyringPath rather than passing the admin key as a --key argument, so the
+// key never appears in a process's command line/ps output.
 func (in *rbdCommandInput) rbdImageStatus(poolName string) (RBDMirrorStatusVerbose, error) {
-	var cmd []byte
 	var rbdMirrorStatusVerbose RBDMirrorStatusVerbose
 
-	if in.monitor == "" && in.id == "" && in.key == "" {
+	if in.monitor == "" && in.id == "" && in.keyringPath == "" {
 		return rbdMirrorStatusVerbose, errors.New("unable to get RBD mirror data. RBD command input not specified")
 	}
 
-	args := []string{"mirror", "pool", "status", poolName, "--verbose", "--format", "json", "-m", in.monitor, "--id", in.id, "--key", in.key, "--debug-rbd", "0"}
-	cmd, err := execCommand("rbd", args)
+	args := []string{"mirror", "pool", "status", poolName, "--verbose", "--format", "json", "-m", in.monitor, "--id", in.id, "--keyring", in.keyringPath}
+	out, err := in.executor.Execute(context.TODO(), "rbd", args)
 	if err != nil {
-		return rbdMirrorStatusVerbose, err
+		return rbdMirrorStatusVerbose, fmt.Errorf("failed to get mirror status for pool %q: %v", poolName, err)
 	}
 
-	err = json.Unmarshal(cmd, &rbdMirrorStatusVerbose)
+	err = json.Unmarshal(out, &rbdMirrorStatusVerbose)
 
 	return rbdMirrorStatusVerbose, err
 }
-
-func execCommand(command string, args []string) ([]byte, error) {
-	cmd := exec.Command(command, args...)
-	return cmd.CombinedOutput()
-}
-
-/*
-	Copied from https://github.com/ceph/ceph-csi/blob/70fc6db2cfe3f00945c030f0d7f83ea1e2d21a00/internal/util/cephconf.go
-	Functions to create ceph.conf and keyring files internally.
-*/
-
-func createCephConfigRoot() error {
-	return os.MkdirAll(cephConfigRoot, 0o755)
-}
-
-// createKeyRingFile creates the keyring files to fix above error message logging.
-func createKeyRingFile() error {
-	var err error
-	if _, err = os.Stat(keyRing); os.IsNotExist(err) {
-		_, err = os.Create(keyRing)
-	}
-
-	return err
-}
-
-// writeCephConfig writes out a basic ceph.conf file, making it easy to use
-// ceph related CLIs.
-func writeCephConfig() error {
-	var err error
-	if err = createCephConfigRoot(); err != nil {
-		return err
-	}
-
-	if _, err = os.Stat(cephConfigPath); os.IsNotExist(err) {
-		err = os.WriteFile(cephConfigPath, cephConfig, 0o600)
-	}
-
-	if err != nil {
-		return err
-	}
-
-	return createKeyRingFile()
-}
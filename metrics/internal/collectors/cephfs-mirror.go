@@ -0,0 +1,190 @@
+package collectors
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/red-hat-storage/ocs-operator/metrics/internal/cache"
+	"k8s.io/klog"
+)
+
+// cephfsMirrorSyncTimestampLayout is the timestamp layout `ceph fs snapshot
+// mirror peer status` reports a directory's last_synced_snap.sync_time_stamp
+// in.
+const cephfsMirrorSyncTimestampLayout = "2006-01-02 15:04:05.000000"
+
+var (
+	cephfsMirrorSnapsSyncedDesc = prometheus.NewDesc(
+		"ocs_cephfs_mirror_snaps_synced_total",
+		"Number of snapshots synced to a CephFS mirror peer",
+		[]string{"filesystem", "namespace", "peer_uuid", "remote_filesystem"},
+		nil,
+	)
+
+	cephfsMirrorSnapsDeletedDesc = prometheus.NewDesc(
+		"ocs_cephfs_mirror_snaps_deleted_total",
+		"Number of snapshots deleted on a CephFS mirror peer",
+		[]string{"filesystem", "namespace", "peer_uuid", "remote_filesystem"},
+		nil,
+	)
+
+	cephfsMirrorSnapsRenamedDesc = prometheus.NewDesc(
+		"ocs_cephfs_mirror_snaps_renamed_total",
+		"Number of snapshots renamed on a CephFS mirror peer",
+		[]string{"filesystem", "namespace", "peer_uuid", "remote_filesystem"},
+		nil,
+	)
+
+	cephfsMirrorSyncBytesDesc = prometheus.NewDesc(
+		"ocs_cephfs_mirror_sync_bytes",
+		"Bytes transferred during the last CephFS mirror sync",
+		[]string{"filesystem", "namespace", "peer_uuid", "remote_filesystem"},
+		nil,
+	)
+
+	cephfsMirrorSyncDurationSecondsDesc = prometheus.NewDesc(
+		"ocs_cephfs_mirror_sync_duration_seconds",
+		"Duration of the last CephFS mirror sync in seconds",
+		[]string{"filesystem", "namespace", "peer_uuid", "remote_filesystem"},
+		nil,
+	)
+
+	cephfsMirrorDirectorySnapsSyncedDesc = prometheus.NewDesc(
+		"ocs_cephfs_mirror_directory_snaps_synced_total",
+		"Number of snapshots synced for a single mirrored CephFS directory",
+		[]string{"filesystem", "namespace", "peer_uuid", "remote_filesystem", "directory"},
+		nil,
+	)
+
+	cephfsMirrorDirectorySnapsDeletedDesc = prometheus.NewDesc(
+		"ocs_cephfs_mirror_directory_snaps_deleted_total",
+		"Number of snapshots deleted for a single mirrored CephFS directory",
+		[]string{"filesystem", "namespace", "peer_uuid", "remote_filesystem", "directory"},
+		nil,
+	)
+
+	cephfsMirrorDirectorySnapsRenamedDesc = prometheus.NewDesc(
+		"ocs_cephfs_mirror_directory_snaps_renamed_total",
+		"Number of snapshots renamed for a single mirrored CephFS directory",
+		[]string{"filesystem", "namespace", "peer_uuid", "remote_filesystem", "directory"},
+		nil,
+	)
+
+	// cephfsMirrorDirectoryStateDesc reports state as its own gauge, the same
+	// way rbdMirrorPoolImageStateCountDesc does, rather than as a label on
+	// the counters above: a directory's state changing between scrapes would
+	// otherwise look like a brand-new counter series to Prometheus, breaking
+	// rate()/increase() over it.
+	cephfsMirrorDirectoryStateDesc = prometheus.NewDesc(
+		"ocs_cephfs_mirror_directory_state",
+		"Current mirror state (1) of a single mirrored CephFS directory",
+		[]string{"filesystem", "namespace", "peer_uuid", "remote_filesystem", "directory", "state"},
+		nil,
+	)
+
+	cephfsMirrorDirectoryLastSyncedSnapBytesDesc = prometheus.NewDesc(
+		"ocs_cephfs_mirror_directory_last_synced_snap_bytes",
+		"Bytes transferred for the last snapshot synced for a mirrored CephFS directory",
+		[]string{"filesystem", "namespace", "peer_uuid", "remote_filesystem", "directory"},
+		nil,
+	)
+
+	cephfsMirrorDirectoryLastSyncedSnapDurationSecondsDesc = prometheus.NewDesc(
+		"ocs_cephfs_mirror_directory_last_synced_snap_duration_seconds",
+		"Duration of the last snapshot synced for a mirrored CephFS directory",
+		[]string{"filesystem", "namespace", "peer_uuid", "remote_filesystem", "directory"},
+		nil,
+	)
+
+	cephfsMirrorDirectoryLastSyncedSnapTimestampSecondsDesc = prometheus.NewDesc(
+		"ocs_cephfs_mirror_directory_last_synced_snap_timestamp_seconds",
+		"Unix timestamp of the last snapshot synced for a mirrored CephFS directory",
+		[]string{"filesystem", "namespace", "peer_uuid", "remote_filesystem", "directory"},
+		nil,
+	)
+)
+
+// CephFSMirrorCollector exports per-directory CephFS subvolume replication
+// metrics cached by CephFSMirrorStore.
+type CephFSMirrorCollector struct {
+	Store *cache.CephFSMirrorStore
+}
+
+// NewCephFSMirrorCollector returns a prometheus.Collector backed by the
+// given CephFSMirrorStore.
+func NewCephFSMirrorCollector(store *cache.CephFSMirrorStore) *CephFSMirrorCollector {
+	return &CephFSMirrorCollector{Store: store}
+}
+
+func (c *CephFSMirrorCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- cephfsMirrorSnapsSyncedDesc
+	ch <- cephfsMirrorSnapsDeletedDesc
+	ch <- cephfsMirrorSnapsRenamedDesc
+	ch <- cephfsMirrorSyncBytesDesc
+	ch <- cephfsMirrorSyncDurationSecondsDesc
+	ch <- cephfsMirrorDirectorySnapsSyncedDesc
+	ch <- cephfsMirrorDirectorySnapsDeletedDesc
+	ch <- cephfsMirrorDirectorySnapsRenamedDesc
+	ch <- cephfsMirrorDirectoryStateDesc
+	ch <- cephfsMirrorDirectoryLastSyncedSnapBytesDesc
+	ch <- cephfsMirrorDirectoryLastSyncedSnapDurationSecondsDesc
+	ch <- cephfsMirrorDirectoryLastSyncedSnapTimestampSecondsDesc
+}
+
+func (c *CephFSMirrorCollector) Collect(ch chan<- prometheus.Metric) {
+	c.Store.Mutex.RLock()
+	filesystems := make([]cache.CephFSMirrorPoolStatusVerbose, 0, len(c.Store.Store))
+	for _, fs := range c.Store.Store {
+		filesystems = append(filesystems, fs)
+	}
+	c.Store.Mutex.RUnlock()
+
+	for _, fs := range filesystems {
+		for _, filesystem := range fs.MirrorStatus.Filesystems {
+			// The daemon status cached for each CephFilesystem lists every
+			// filesystem the daemon mirrors; only export this entry's own.
+			if filesystem.Name != fs.FilesystemName {
+				continue
+			}
+			for _, peer := range filesystem.Peers {
+				labels := []string{fs.FilesystemName, fs.Namespace, peer.PeerUUID, peer.Remote.FsName}
+
+				ch <- prometheus.MustNewConstMetric(cephfsMirrorSnapsSyncedDesc, prometheus.CounterValue, float64(peer.SnapsSynced), labels...)
+				ch <- prometheus.MustNewConstMetric(cephfsMirrorSnapsDeletedDesc, prometheus.CounterValue, float64(peer.SnapsDeleted), labels...)
+				ch <- prometheus.MustNewConstMetric(cephfsMirrorSnapsRenamedDesc, prometheus.CounterValue, float64(peer.SnapsRenamed), labels...)
+				ch <- prometheus.MustNewConstMetric(cephfsMirrorSyncBytesDesc, prometheus.GaugeValue, float64(peer.SyncBytes), labels...)
+				ch <- prometheus.MustNewConstMetric(cephfsMirrorSyncDurationSecondsDesc, prometheus.GaugeValue, peer.SyncDuration, labels...)
+
+				for directory, dirStatus := range peer.Directories {
+					c.collectDirectory(ch, fs, peer, directory, dirStatus)
+				}
+			}
+		}
+	}
+}
+
+func (c *CephFSMirrorCollector) collectDirectory(ch chan<- prometheus.Metric, fs cache.CephFSMirrorPoolStatusVerbose, peer cache.CephFSMirrorPeerStatus, directory string, dirStatus cache.CephFSMirrorDirectoryStatus) {
+	labels := []string{fs.FilesystemName, fs.Namespace, peer.PeerUUID, peer.Remote.FsName, directory}
+
+	ch <- prometheus.MustNewConstMetric(cephfsMirrorDirectorySnapsSyncedDesc, prometheus.CounterValue, float64(dirStatus.SnapsSynced), labels...)
+	ch <- prometheus.MustNewConstMetric(cephfsMirrorDirectorySnapsDeletedDesc, prometheus.CounterValue, float64(dirStatus.SnapsDeleted), labels...)
+	ch <- prometheus.MustNewConstMetric(cephfsMirrorDirectorySnapsRenamedDesc, prometheus.CounterValue, float64(dirStatus.SnapsRenamed), labels...)
+	ch <- prometheus.MustNewConstMetric(cephfsMirrorDirectoryStateDesc, prometheus.GaugeValue, 1, append(append([]string{}, labels...), dirStatus.State)...)
+
+	lastSynced := dirStatus.LastSyncedSnap
+	ch <- prometheus.MustNewConstMetric(cephfsMirrorDirectoryLastSyncedSnapBytesDesc, prometheus.GaugeValue, float64(lastSynced.SyncBytes), labels...)
+	ch <- prometheus.MustNewConstMetric(cephfsMirrorDirectoryLastSyncedSnapDurationSecondsDesc, prometheus.GaugeValue, lastSynced.SyncDuration, labels...)
+
+	if lastSynced.SyncTimeStamp == "" {
+		return
+	}
+
+	// ceph reports sync_time_stamp in the local wall-clock time of the host
+	// the command ran on (the toolbox/exporter container), not UTC.
+	syncedAt, err := time.ParseInLocation(cephfsMirrorSyncTimestampLayout, lastSynced.SyncTimeStamp, time.Local)
+	if err != nil {
+		klog.Errorf("failed to parse cephfs mirror last_synced_snap sync_time_stamp %q for filesystem %s/%s directory %q: %v", lastSynced.SyncTimeStamp, fs.Namespace, fs.FilesystemName, directory, err)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(cephfsMirrorDirectoryLastSyncedSnapTimestampSecondsDesc, prometheus.GaugeValue, float64(syncedAt.Unix()), labels...)
+}
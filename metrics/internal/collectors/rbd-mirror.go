@@ -0,0 +1,132 @@
+package collectors
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/red-hat-storage/ocs-operator/metrics/internal/cache"
+	"k8s.io/klog"
+)
+
+// rbdMirrorLastUpdateLayout is the timestamp layout `rbd mirror pool
+// status --verbose` reports peer site "last_update" in.
+const rbdMirrorLastUpdateLayout = "2006-01-02 15:04:05"
+
+var (
+	rbdMirrorImageBytesPerSecondDesc = prometheus.NewDesc(
+		"ocs_rbd_mirror_image_bytes_per_second",
+		"RBD mirror image replication throughput in bytes per second",
+		[]string{"pool", "namespace", "image", "site_name", "mirror_uuid", "replay_state"},
+		nil,
+	)
+
+	rbdMirrorImageBytesPerSnapshotDesc = prometheus.NewDesc(
+		"ocs_rbd_mirror_image_bytes_per_snapshot",
+		"RBD mirror image bytes transferred per snapshot",
+		[]string{"pool", "namespace", "image", "site_name", "mirror_uuid", "replay_state"},
+		nil,
+	)
+
+	rbdMirrorImageSnapshotLagSecondsDesc = prometheus.NewDesc(
+		"ocs_rbd_mirror_image_snapshot_lag_seconds",
+		"Seconds by which the remote snapshot lags behind the local snapshot for a mirrored RBD image",
+		[]string{"pool", "namespace", "image", "site_name", "mirror_uuid", "replay_state"},
+		nil,
+	)
+
+	rbdMirrorImageLastUpdateTimestampSecondsDesc = prometheus.NewDesc(
+		"ocs_rbd_mirror_image_last_update_timestamp_seconds",
+		"Unix timestamp of the last mirror status update for an RBD image peer site",
+		[]string{"pool", "namespace", "image", "site_name", "mirror_uuid", "replay_state"},
+		nil,
+	)
+
+	rbdMirrorPoolImageStateCountDesc = prometheus.NewDesc(
+		"ocs_rbd_mirror_pool_image_state_count",
+		"Number of RBD images in a pool in a given mirror replication state",
+		[]string{"pool", "namespace", "state"},
+		nil,
+	)
+)
+
+// RBDMirrorCollector exports per-image replication-lag metrics derived from
+// the peer site descriptions that RBDMirrorStore caches for each mirrored
+// CephBlockPool.
+type RBDMirrorCollector struct {
+	Store *cache.RBDMirrorStore
+}
+
+// NewRBDMirrorCollector returns a prometheus.Collector backed by the given
+// RBDMirrorStore.
+func NewRBDMirrorCollector(store *cache.RBDMirrorStore) *RBDMirrorCollector {
+	return &RBDMirrorCollector{Store: store}
+}
+
+func (c *RBDMirrorCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- rbdMirrorImageBytesPerSecondDesc
+	ch <- rbdMirrorImageBytesPerSnapshotDesc
+	ch <- rbdMirrorImageSnapshotLagSecondsDesc
+	ch <- rbdMirrorImageLastUpdateTimestampSecondsDesc
+	ch <- rbdMirrorPoolImageStateCountDesc
+}
+
+func (c *RBDMirrorCollector) Collect(ch chan<- prometheus.Metric) {
+	pools := c.Store.Snapshot()
+
+	for _, pool := range pools {
+		c.collectPoolImageStateCount(ch, pool)
+
+		for _, image := range pool.MirrorStatus.Images {
+			for _, site := range image.PeerSites {
+				c.collectPeerSite(ch, pool, image, site)
+			}
+		}
+	}
+}
+
+func (c *RBDMirrorCollector) collectPeerSite(ch chan<- prometheus.Metric, pool cache.RBDMirrorPoolStatusVerbose, image cache.RBDMirrorImageStatus, site cache.RBDMirrorPeerSite) {
+	var desc cache.RBDMirrorPeerSiteDescription
+	if err := json.Unmarshal([]byte(site.Description), &desc); err != nil {
+		klog.Errorf("failed to unmarshal rbd mirror peer site description for pool %s/%s image %s: %v", pool.PoolNamespace, pool.PoolName, image.Name, err)
+		return
+	}
+
+	labels := []string{pool.PoolName, pool.PoolNamespace, image.Name, site.SiteName, site.MirrorUuids, desc.ReplayState}
+
+	ch <- prometheus.MustNewConstMetric(rbdMirrorImageBytesPerSecondDesc, prometheus.GaugeValue, desc.BytesPerSecond, labels...)
+	ch <- prometheus.MustNewConstMetric(rbdMirrorImageBytesPerSnapshotDesc, prometheus.GaugeValue, desc.BytesPerSnapshot, labels...)
+
+	lag := desc.LocalSnapshotTimestamp - desc.RemoteSnapshotTimestamp
+	if lag < 0 {
+		lag = 0
+	}
+	ch <- prometheus.MustNewConstMetric(rbdMirrorImageSnapshotLagSecondsDesc, prometheus.GaugeValue, float64(lag), labels...)
+
+	// rbd reports last_update in the local wall-clock time of the host the
+	// command ran on (the toolbox/exporter container), not UTC.
+	lastUpdate, err := time.ParseInLocation(rbdMirrorLastUpdateLayout, site.LastUpdate, time.Local)
+	if err != nil {
+		klog.Errorf("failed to parse rbd mirror peer site last_update %q for pool %s/%s image %s: %v", site.LastUpdate, pool.PoolNamespace, pool.PoolName, image.Name, err)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(rbdMirrorImageLastUpdateTimestampSecondsDesc, prometheus.GaugeValue, float64(lastUpdate.Unix()), labels...)
+}
+
+func (c *RBDMirrorCollector) collectPoolImageStateCount(ch chan<- prometheus.Metric, pool cache.RBDMirrorPoolStatusVerbose) {
+	states := pool.MirrorStatus.Summary.States
+
+	counts := map[string]int{
+		"unknown":         states.Unknown,
+		"error":           states.Error,
+		"syncing":         states.Syncing,
+		"starting_replay": states.StartingReplay,
+		"replaying":       states.Replaying,
+		"stopping_replay": states.StoppingReplay,
+		"stopped":         states.Stopped,
+	}
+
+	for state, count := range counts {
+		ch <- prometheus.MustNewConstMetric(rbdMirrorPoolImageStateCountDesc, prometheus.GaugeValue, float64(count), pool.PoolName, pool.PoolNamespace, state)
+	}
+}
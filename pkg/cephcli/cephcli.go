@@ -0,0 +1,179 @@
+// Package cephcli provides the single blessed way for OCS controllers and
+// exporters to invoke ceph admin CLI commands, either directly (LocalExec)
+// or by exec'ing into the reconciled rook-ceph-tools toolbox (ToolboxExec).
+package cephcli
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// CephCLIExecutor runs a ceph admin CLI command (e.g. "rbd", "ceph") with
+// the given arguments and returns its output. LocalExec returns combined
+// stdout/stderr; ToolboxExec returns stdout alone on success, and stdout
+// followed by stderr on failure.
+type CephCLIExecutor interface {
+	Execute(ctx context.Context, command string, args []string) ([]byte, error)
+
+	// WriteFile stages data (e.g. a keyring or a bootstrap token) at path,
+	// in the same place Execute's commands run, so callers never need to
+	// put secrets on a command line to get them there.
+	WriteFile(ctx context.Context, path string, data []byte) error
+}
+
+// LocalExec runs the command against the binaries installed in the calling
+// process's own container. This is today's behavior and requires the
+// caller's container to carry ceph client binaries and admin credentials.
+type LocalExec struct{}
+
+func (LocalExec) Execute(ctx context.Context, command string, args []string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, command, args...)
+	return cmd.CombinedOutput()
+}
+
+func (LocalExec) WriteFile(ctx context.Context, path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o600)
+}
+
+const (
+	// toolboxDeploymentName is the rook-ceph-tools deployment reconciled by
+	// StorageClusterReconciler.ensureToolsDeployment.
+	toolboxDeploymentName = "rook-ceph-tools"
+	toolboxContainerName  = "rook-ceph-tools"
+)
+
+// ToolboxExec execs the command into the reconciled rook-ceph-tools
+// deployment over the Kubernetes API, so the calling container never needs
+// ceph client binaries or admin credentials of its own.
+type ToolboxExec struct {
+	RESTConfig *rest.Config
+	Kubeclient kubernetes.Interface
+	Namespace  string
+}
+
+// NewToolboxExec builds a ToolboxExec that execs into the rook-ceph-tools
+// deployment in the given namespace.
+func NewToolboxExec(restConfig *rest.Config, kubeclient kubernetes.Interface, namespace string) *ToolboxExec {
+	return &ToolboxExec{
+		RESTConfig: restConfig,
+		Kubeclient: kubeclient,
+		Namespace:  namespace,
+	}
+}
+
+func (t *ToolboxExec) Execute(ctx context.Context, command string, args []string) ([]byte, error) {
+	pod, err := t.toolboxPod(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	req := t.Kubeclient.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Name(pod.Name).
+		Namespace(t.Namespace).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: toolboxContainerName,
+			Command:   append([]string{command}, args...),
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+
+	executor, err := remotecommand.NewSPDYExecutor(t.RESTConfig, "POST", req.URL())
+	if err != nil {
+		return nil, fmt.Errorf("failed to build exec client for toolbox pod %q: %v", pod.Name, err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	err = executor.StreamWithContext(ctx, remotecommand.StreamOptions{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	})
+	if err != nil {
+		return append(stdout.Bytes(), stderr.Bytes()...), fmt.Errorf("exec into toolbox pod %q failed: %v: %s", pod.Name, err, stderr.String())
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// WriteFile stages data inside the toolbox pod by exec'ing a shell that
+// redirects its stdin to path, streaming data in over stdin rather than
+// ever putting it in the exec'd command's argv.
+func (t *ToolboxExec) WriteFile(ctx context.Context, path string, data []byte) error {
+	pod, err := t.toolboxPod(ctx)
+	if err != nil {
+		return err
+	}
+
+	script := fmt.Sprintf("mkdir -p %q && cat > %q", filepath.Dir(path), path)
+	req := t.Kubeclient.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Name(pod.Name).
+		Namespace(t.Namespace).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: toolboxContainerName,
+			Command:   []string{"sh", "-c", script},
+			Stdin:     true,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+
+	executor, err := remotecommand.NewSPDYExecutor(t.RESTConfig, "POST", req.URL())
+	if err != nil {
+		return fmt.Errorf("failed to build exec client for toolbox pod %q: %v", pod.Name, err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	err = executor.StreamWithContext(ctx, remotecommand.StreamOptions{
+		Stdin:  bytes.NewReader(data),
+		Stdout: &stdout,
+		Stderr: &stderr,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write %q in toolbox pod %q: %v: %s", path, pod.Name, err, stderr.String())
+	}
+
+	return nil
+}
+
+// NewExecutor picks LocalExec or ToolboxExec based on opts.UseToolboxExec,
+// so callers can switch the egress path for ceph admin commands with a
+// single flag rather than hand-wiring the choice at every call site.
+func NewExecutor(useToolboxExec bool, restConfig *rest.Config, kubeclient kubernetes.Interface, namespace string) CephCLIExecutor {
+	if useToolboxExec {
+		return NewToolboxExec(restConfig, kubeclient, namespace)
+	}
+	return LocalExec{}
+}
+
+func (t *ToolboxExec) toolboxPod(ctx context.Context) (*corev1.Pod, error) {
+	pods, err := t.Kubeclient.CoreV1().Pods(t.Namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("app=%s", toolboxDeploymentName),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %q pods: %v", toolboxDeploymentName, err)
+	}
+
+	for i := range pods.Items {
+		if pods.Items[i].Status.Phase == corev1.PodRunning {
+			return &pods.Items[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("no running %q pod found in namespace %q", toolboxDeploymentName, t.Namespace)
+}